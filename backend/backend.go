@@ -0,0 +1,70 @@
+// Package backend provides a pluggable storage abstraction so that dumps can
+// be shipped to S3-compatible object stores, a local filesystem path, or an
+// SFTP server without recompiling the binary.
+package backend
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// PutOptions carries the metadata callers want attached to an uploaded
+// object. Backends that have no concept of one of these fields (e.g. the
+// local filesystem) are free to ignore it.
+type PutOptions struct {
+	ContentType     string
+	ContentEncoding string
+}
+
+// ObjectInfo describes an object that was successfully stored.
+type ObjectInfo struct {
+	// Bucket is the bucket or root the object was stored under. Backends
+	// without the concept of a bucket (local, sftp) leave this empty.
+	Bucket string
+	Key    string
+	Size   int64
+}
+
+// Backend is a destination dumps can be streamed to. Implementations are
+// constructed by NewFromEnv and selected at runtime via the PROVIDER env var.
+type Backend interface {
+	// Put streams r to the object identified by key. size may be -1 if the
+	// caller doesn't know the length up front.
+	Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) (ObjectInfo, error)
+
+	// PresignedURL returns a URL the caller can use to download the object
+	// identified by key within expiry, plus an optional human-readable note
+	// (e.g. instructions for headers the caller must supply for an
+	// encrypted object). The sftp backend cannot produce a URL and returns
+	// an error; the local backend returns a file:// URL of the stored file.
+	PresignedURL(ctx context.Context, key string, expiry time.Duration) (url string, note string, err error)
+
+	// Exists reports whether an object already exists under key, so callers
+	// can skip re-uploading content-addressed chunks that were written by a
+	// previous run.
+	Exists(ctx context.Context, key string) (bool, error)
+
+	// Compose assembles the objects identified by srcKeys, in order, into a
+	// single new object at destKey.
+	Compose(ctx context.Context, destKey string, srcKeys []string) error
+
+	// Get opens the object identified by key for reading. The caller must
+	// close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Latest returns the key of the most recently stored dump object (see
+	// compression.IsDumpKey) under prefix; other objects such as manifests
+	// are not considered. Keys are compared lexicographically (dump keys are timestamped
+	// with RFC3339, so this also orders them by time).
+	Latest(ctx context.Context, prefix string) (string, error)
+}
+
+// LifecycleManager is implemented by backends that can enforce a retention
+// policy on the objects they store. Backends without that concept (local,
+// sftp) simply don't implement it; callers should type-assert for it.
+type LifecycleManager interface {
+	// EnsureLifecycle makes sure the backend's retention policy matches the
+	// one configured in the environment, applying it if necessary.
+	EnsureLifecycle(ctx context.Context) error
+}
@@ -0,0 +1,30 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// NewFromEnv constructs a Backend based on the PROVIDER env var ("s3",
+// "local", or "sftp"), reading the provider-specific settings each
+// implementation needs from the environment. secrets are the credentials
+// pulled from the Lockbox secret bundle, used by providers that need them
+// ("s3" and "sftp").
+func NewFromEnv(ctx context.Context, secrets map[string]string) (Backend, error) {
+	provider := os.Getenv("PROVIDER")
+	if provider == "" {
+		provider = "s3"
+	}
+
+	switch provider {
+	case "s3":
+		return newS3BackendFromEnv(secrets)
+	case "local":
+		return newLocalBackendFromEnv()
+	case "sftp":
+		return newSFTPBackendFromEnv(ctx, secrets)
+	default:
+		return nil, fmt.Errorf("unknown PROVIDER %q", provider)
+	}
+}
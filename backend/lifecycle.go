@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// EnsureLifecycle makes sure the bucket has a lifecycle rule expiring (and,
+// optionally, transitioning to cold storage) objects under the "go/" prefix
+// that dumps are written under, per RETENTION_DAYS and TRANSITION_DAYS. It's
+// meant to be called once per cold start so operators don't accumulate
+// unbounded dumps. With LIFECYCLE_DRY_RUN=true it only logs the rule it
+// would apply.
+func (b *s3Backend) EnsureLifecycle(ctx context.Context) error {
+	retentionDays := os.Getenv("RETENTION_DAYS")
+	if retentionDays == "" {
+		return nil
+	}
+
+	retention, err := strconv.Atoi(retentionDays)
+	if err != nil {
+		return fmt.Errorf("invalid RETENTION_DAYS %q: %w", retentionDays, err)
+	}
+
+	rule := lifecycle.Rule{
+		ID:     "ycf-dump-retention",
+		Status: "Enabled",
+		RuleFilter: lifecycle.Filter{
+			Prefix: "go/",
+		},
+		Expiration: lifecycle.Expiration{
+			Days: lifecycle.ExpirationDays(retention),
+		},
+	}
+
+	if transitionDays := os.Getenv("TRANSITION_DAYS"); transitionDays != "" {
+		transition, err := strconv.Atoi(transitionDays)
+		if err != nil {
+			return fmt.Errorf("invalid TRANSITION_DAYS %q: %w", transitionDays, err)
+		}
+		rule.Transition = lifecycle.Transition{
+			Days:         lifecycle.ExpirationDays(transition),
+			StorageClass: "COLD",
+		}
+	}
+
+	// The bucket may already carry lifecycle rules for other prefixes or
+	// teams, so fetch and upsert by ID instead of overwriting wholesale.
+	cfg, err := b.client.GetBucketLifecycle(ctx, b.bucket)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+			return fmt.Errorf("can't get existing bucket lifecycle: %w", err)
+		}
+		cfg = lifecycle.NewConfiguration()
+	}
+
+	merged := make([]lifecycle.Rule, 0, len(cfg.Rules)+1)
+	for _, existing := range cfg.Rules {
+		if existing.ID != rule.ID {
+			merged = append(merged, existing)
+		}
+	}
+	cfg.Rules = append(merged, rule)
+
+	if os.Getenv("LIFECYCLE_DRY_RUN") == "true" {
+		log.Printf("dry run: would set lifecycle rule on %s: %+v", b.bucket, rule)
+		return nil
+	}
+
+	if err := b.client.SetBucketLifecycle(ctx, b.bucket, cfg); err != nil {
+		return fmt.Errorf("can't set bucket lifecycle: %w", err)
+	}
+
+	return nil
+}
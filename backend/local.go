@@ -0,0 +1,130 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Nyoroon/ycf-dump/compression"
+)
+
+// localBackend stores objects as plain files under a root directory. It's
+// meant for air-gapped setups where dumps need to land on disk rather than
+// in an object store.
+type localBackend struct {
+	root string
+}
+
+// newLocalBackendFromEnv builds a local filesystem backend rooted at
+// LOCAL_PATH.
+func newLocalBackendFromEnv() (Backend, error) {
+	root := os.Getenv("LOCAL_PATH")
+	if root == "" {
+		return nil, fmt.Errorf("LOCAL_PATH is required for PROVIDER=local")
+	}
+
+	return &localBackend{root: root}, nil
+}
+
+func (b *localBackend) Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) (ObjectInfo, error) {
+	dst := filepath.Join(b.root, filepath.FromSlash(key))
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return ObjectInfo{}, fmt.Errorf("can't create dump directory: %w", err)
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("can't create dump file: %w", err)
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("can't write dump file: %w", err)
+	}
+
+	return ObjectInfo{Key: key, Size: n}, nil
+}
+
+func (b *localBackend) PresignedURL(ctx context.Context, key string, expiry time.Duration) (string, string, error) {
+	return "file://" + filepath.Join(b.root, filepath.FromSlash(key)), "", nil
+}
+
+func (b *localBackend) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(filepath.Join(b.root, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *localBackend) Compose(ctx context.Context, destKey string, srcKeys []string) error {
+	dst := filepath.Join(b.root, filepath.FromSlash(destKey))
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("can't create dump directory: %w", err)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("can't create composed file: %w", err)
+	}
+	defer out.Close()
+
+	for _, key := range srcKeys {
+		if err := appendFile(out, filepath.Join(b.root, filepath.FromSlash(key))); err != nil {
+			return fmt.Errorf("can't append %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func appendFile(dst *os.File, srcPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func (b *localBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(b.root, filepath.FromSlash(key)))
+}
+
+func (b *localBackend) Latest(ctx context.Context, prefix string) (string, error) {
+	var latest string
+
+	err := fs.WalkDir(os.DirFS(b.root), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(p)
+		if !d.IsDir() && strings.HasPrefix(key, prefix) && compression.IsDumpKey(key) && key > latest {
+			latest = key
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("no objects found under prefix %q", prefix)
+	}
+
+	return latest, nil
+}
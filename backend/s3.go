@@ -0,0 +1,257 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+
+	"github.com/Nyoroon/ycf-dump/compression"
+)
+
+// s3Backend stores objects in any S3-compatible object store: AWS, Yandex
+// Object Storage, MinIO, Ceph RGW, Backblaze B2, etc.
+type s3Backend struct {
+	client *minio.Client
+	bucket string
+	sse    encrypt.ServerSide
+}
+
+// newServerSideEncryption builds the encrypt.ServerSide value matching
+// ENCRYPTION_MODE ("sse-s3", "sse-c", "sse-kms", or "" for none), pulling
+// the customer key or KMS key ID out of the Lockbox secret bundle. Dumps of
+// `/` routinely contain secrets and ssh keys, so encryption at rest is
+// opt-in but cheap to turn on.
+func newServerSideEncryption(secrets map[string]string) (encrypt.ServerSide, error) {
+	switch mode := os.Getenv("ENCRYPTION_MODE"); mode {
+	case "":
+		return nil, nil
+	case "sse-s3":
+		return encrypt.NewSSE(), nil
+	case "sse-c":
+		key, err := base64.StdEncoding.DecodeString(secrets["SSE_C_KEY"])
+		if err != nil {
+			return nil, fmt.Errorf("can't decode SSE_C_KEY: %w", err)
+		}
+		return encrypt.NewSSEC(key)
+	case "sse-kms":
+		return encrypt.NewSSEKMS(secrets["SSE_KMS_KEY_ID"], nil)
+	default:
+		return nil, fmt.Errorf("unknown ENCRYPTION_MODE %q", mode)
+	}
+}
+
+// newS3BackendFromEnv builds an S3-compatible backend from the
+// S3_ENDPOINT, S3_REGION and BUCKET env vars, falling back to Yandex Object
+// Storage's endpoint for backwards compatibility with existing deployments.
+func newS3BackendFromEnv(secrets map[string]string) (Backend, error) {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "storage.yandexcloud.net"
+	}
+
+	opts := &minio.Options{
+		Creds:  credentials.NewStaticV4(secrets["AWS_ACCESS_KEY"], secrets["AWS_SECRET_KEY"], ""),
+		Secure: true,
+		Region: os.Getenv("S3_REGION"),
+	}
+
+	client, err := minio.New(endpoint, opts)
+	if err != nil {
+		return nil, fmt.Errorf("can't create s3 client: %w", err)
+	}
+
+	bucket := os.Getenv("BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("BUCKET is required for PROVIDER=s3")
+	}
+
+	sse, err := newServerSideEncryption(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("can't set up server-side encryption: %w", err)
+	}
+
+	return &s3Backend{client: client, bucket: bucket, sse: sse}, nil
+}
+
+func (b *s3Backend) Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) (ObjectInfo, error) {
+	info, err := b.client.PutObject(
+		ctx,
+		b.bucket,
+		key,
+		r,
+		size,
+		minio.PutObjectOptions{
+			ContentType:          opts.ContentType,
+			ContentEncoding:      opts.ContentEncoding,
+			PartSize:             5 * 1024 * 1024,
+			ServerSideEncryption: b.sse,
+		},
+	)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{Bucket: info.Bucket, Key: info.Key, Size: info.Size}, nil
+}
+
+func (b *s3Backend) PresignedURL(ctx context.Context, key string, expiry time.Duration) (string, string, error) {
+	// SSE-C requires the customer key to travel as a header on the actual
+	// GET request, which a bare presigned URL can't carry (and shouldn't -
+	// the key is a secret, not something to hand an external URL holder).
+	// There's no working presigned-URL story for SSE-C objects, but the
+	// download still needs to be possible, so hand back the key instead
+	// of a URL; the caller fetches it via RestoreHandler/Get, which do
+	// carry the customer key.
+	if b.sse != nil && b.sse.Type() == encrypt.SSEC {
+		return "", fmt.Sprintf("object %s is encrypted with SSE-C; presigned URLs aren't supported, "+
+			"fetch it via RestoreHandler?key=%s&root=<restore target> instead", key, key), nil
+	}
+
+	u, err := b.client.PresignedGetObject(ctx, b.bucket, key, expiry, url.Values{})
+	if err != nil {
+		return "", "", err
+	}
+
+	return u.String(), "", nil
+}
+
+func (b *s3Backend) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := b.client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{ServerSideEncryption: b.sse})
+	if err != nil {
+		errResp := minio.ToErrorResponse(err)
+		if errResp.Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// s3MinPartSize is S3's minimum size for every part of a server-side
+// multipart copy except the last. Chunks are compressed independently
+// before upload (see chunk.go), so an 8 MiB raw chunk of ordinary rootfs
+// content routinely compresses well under this.
+const s3MinPartSize = 5 * 1024 * 1024
+
+func (b *s3Backend) Compose(ctx context.Context, destKey string, srcKeys []string) error {
+	canCopy, err := b.canComposeServerSide(ctx, srcKeys)
+	if err != nil {
+		return err
+	}
+	if canCopy {
+		return b.composeServerSide(ctx, destKey, srcKeys)
+	}
+
+	// At least one non-final chunk is too small for a server-side
+	// multipart copy; fall back to streaming every chunk through this
+	// process instead, since minio.PutObject has no such part-size floor.
+	return b.composeByDownload(ctx, destKey, srcKeys)
+}
+
+// canComposeServerSide reports whether every srcKey but the last is at
+// least s3MinPartSize, the requirement minio.Client.ComposeObject's
+// underlying multipart copy imposes on all but the final part.
+func (b *s3Backend) canComposeServerSide(ctx context.Context, srcKeys []string) (bool, error) {
+	if len(srcKeys) == 0 {
+		return true, nil
+	}
+
+	for _, key := range srcKeys[:len(srcKeys)-1] {
+		info, err := b.client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{ServerSideEncryption: b.sse})
+		if err != nil {
+			return false, fmt.Errorf("can't stat chunk %s: %w", key, err)
+		}
+		if info.Size < s3MinPartSize {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (b *s3Backend) composeServerSide(ctx context.Context, destKey string, srcKeys []string) error {
+	srcs := make([]minio.CopySrcOptions, len(srcKeys))
+	for i, key := range srcKeys {
+		srcs[i] = minio.CopySrcOptions{Bucket: b.bucket, Object: key}
+	}
+
+	dst := minio.CopyDestOptions{
+		Bucket:     b.bucket,
+		Object:     destKey,
+		Encryption: b.sse,
+	}
+
+	_, err := b.client.ComposeObject(ctx, dst, srcs...)
+	return err
+}
+
+// composeByDownload builds destKey by streaming each chunk's bytes through
+// this process in order and re-uploading them as a single object, rather
+// than relying on a server-side copy.
+func (b *s3Backend) composeByDownload(ctx context.Context, destKey string, srcKeys []string) error {
+	pr, pw := io.Pipe()
+
+	go func() {
+		var err error
+		for _, key := range srcKeys {
+			var obj *minio.Object
+			obj, err = b.client.GetObject(ctx, b.bucket, key, minio.GetObjectOptions{ServerSideEncryption: b.sse})
+			if err == nil {
+				_, err = io.Copy(pw, obj)
+				obj.Close()
+			}
+			if err != nil {
+				err = fmt.Errorf("can't read chunk %s: %w", key, err)
+				break
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+
+	_, err := b.client.PutObject(ctx, b.bucket, destKey, pr, -1, minio.PutObjectOptions{
+		ContentType:          "application/octet-stream",
+		PartSize:             5 * 1024 * 1024,
+		ServerSideEncryption: b.sse,
+	})
+	return err
+}
+
+func (b *s3Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := b.client.GetObject(ctx, b.bucket, key, minio.GetObjectOptions{ServerSideEncryption: b.sse})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func (b *s3Backend) Latest(ctx context.Context, prefix string) (string, error) {
+	var latest string
+	for obj := range b.client.ListObjects(ctx, b.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return "", obj.Err
+		}
+		if compression.IsDumpKey(obj.Key) && obj.Key > latest {
+			latest = obj.Key
+		}
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("no objects found under prefix %q", prefix)
+	}
+
+	return latest, nil
+}
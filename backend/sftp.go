@@ -0,0 +1,170 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/Nyoroon/ycf-dump/compression"
+)
+
+// sftpBackend stores objects as files on a remote host over SFTP, under a
+// fixed remote directory.
+type sftpBackend struct {
+	client *sftp.Client
+	root   string
+}
+
+// newSFTPBackendFromEnv dials SFTP_HOST as SFTP_USER, authenticating with
+// the SFTP_PRIVATE_KEY (or, if unset, SFTP_PASSWORD) entry from the Lockbox
+// secret bundle, and stores objects under SFTP_PATH.
+func newSFTPBackendFromEnv(ctx context.Context, secrets map[string]string) (Backend, error) {
+	host := os.Getenv("SFTP_HOST")
+	if host == "" {
+		return nil, fmt.Errorf("SFTP_HOST is required for PROVIDER=sftp")
+	}
+
+	user := os.Getenv("SFTP_USER")
+	root := os.Getenv("SFTP_PATH")
+
+	auth, err := sftpAuthMethod(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't dial sftp host: %w", err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("can't create sftp client: %w", err)
+	}
+
+	return &sftpBackend{client: client, root: root}, nil
+}
+
+func sftpAuthMethod(secrets map[string]string) (ssh.AuthMethod, error) {
+	if key := secrets["SFTP_PRIVATE_KEY"]; key != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("can't parse sftp private key: %w", err)
+		}
+		return ssh.PublicKeys(signer), nil
+	}
+
+	if pass, ok := secrets["SFTP_PASSWORD"]; ok {
+		return ssh.Password(pass), nil
+	}
+
+	return nil, fmt.Errorf("no SFTP_PRIVATE_KEY or SFTP_PASSWORD found in secrets")
+}
+
+func (b *sftpBackend) Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) (ObjectInfo, error) {
+	dst := path.Join(b.root, key)
+
+	if err := b.client.MkdirAll(path.Dir(dst)); err != nil {
+		return ObjectInfo{}, fmt.Errorf("can't create remote directory: %w", err)
+	}
+
+	f, err := b.client.Create(dst)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("can't create remote file: %w", err)
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("can't write remote file: %w", err)
+	}
+
+	return ObjectInfo{Key: key, Size: n}, nil
+}
+
+func (b *sftpBackend) PresignedURL(ctx context.Context, key string, expiry time.Duration) (string, string, error) {
+	return "", "", fmt.Errorf("sftp backend does not support presigned urls; fetch %s directly", path.Join(b.root, key))
+}
+
+func (b *sftpBackend) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := b.client.Stat(path.Join(b.root, key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *sftpBackend) Compose(ctx context.Context, destKey string, srcKeys []string) error {
+	dst := path.Join(b.root, destKey)
+
+	if err := b.client.MkdirAll(path.Dir(dst)); err != nil {
+		return fmt.Errorf("can't create remote directory: %w", err)
+	}
+
+	out, err := b.client.Create(dst)
+	if err != nil {
+		return fmt.Errorf("can't create composed remote file: %w", err)
+	}
+	defer out.Close()
+
+	for _, key := range srcKeys {
+		src, err := b.client.Open(path.Join(b.root, key))
+		if err != nil {
+			return fmt.Errorf("can't open %s: %w", key, err)
+		}
+
+		_, err = io.Copy(out, src)
+		src.Close()
+		if err != nil {
+			return fmt.Errorf("can't append %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *sftpBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.client.Open(path.Join(b.root, key))
+}
+
+func (b *sftpBackend) Latest(ctx context.Context, prefix string) (string, error) {
+	var latest string
+
+	walker := b.client.Walk(path.Join(b.root, prefix))
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return "", err
+		}
+
+		key, err := filepath.Rel(b.root, walker.Path())
+		if err != nil {
+			return "", err
+		}
+		key = filepath.ToSlash(key)
+
+		if !walker.Stat().IsDir() && compression.IsDumpKey(key) && key > latest {
+			latest = key
+		}
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("no objects found under prefix %q", prefix)
+	}
+
+	return latest, nil
+}
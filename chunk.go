@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Nyoroon/ycf-dump/backend"
+	"github.com/Nyoroon/ycf-dump/compression"
+)
+
+// chunkSize is the block size dumps are split into before upload. 8 MiB
+// keeps the chunk count for a typical rootfs manageable while still giving
+// good dedup granularity between runs.
+const chunkSize = 8 * 1024 * 1024
+
+// chunkUploader is an io.Writer that splits whatever is written to it into
+// chunkSize, content-addressed blocks, compresses each block independently
+// with algo/level, and uploads it to store under chunks/<suffix>/<sha256 of
+// the uncompressed block>, where suffix is compression.Suffix(algo) (e.g.
+// "zst" for "zstd"), skipping any block the store already has.
+//
+// Chunking has to happen on the uncompressed tar stream: gzip/zstd/xz are
+// stateful, so hashing compressor output would mean a single changed file
+// anywhere in the walk reshuffles every chunk hash after it, and two runs
+// of a real rootfs would share almost no chunks. Compressing each chunk on
+// its own instead relies on gzip/zstd/xz decoders transparently handling
+// concatenated streams, which is how the composed object is later decoded
+// as one continuous stream by RestoreHandler.
+type chunkUploader struct {
+	ctx   context.Context
+	store backend.Backend
+	algo  string
+	level int
+	buf   []byte
+
+	keys []string
+}
+
+func newChunkUploader(ctx context.Context, store backend.Backend, algo string, level int) *chunkUploader {
+	return &chunkUploader{
+		ctx:   ctx,
+		store: store,
+		algo:  algo,
+		level: level,
+		buf:   make([]byte, 0, chunkSize),
+	}
+}
+
+func (c *chunkUploader) Write(p []byte) (int, error) {
+	written := len(p)
+
+	for len(p) > 0 {
+		n := copy(c.buf[len(c.buf):cap(c.buf)], p)
+		c.buf = c.buf[:len(c.buf)+n]
+		p = p[n:]
+
+		if len(c.buf) == cap(c.buf) {
+			if err := c.flush(); err != nil {
+				return written - len(p), err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Close flushes any partial final chunk. It does not close the underlying
+// store.
+func (c *chunkUploader) Close() error {
+	return c.flush()
+}
+
+func (c *chunkUploader) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	sum := sha256.Sum256(c.buf)
+	hash := hex.EncodeToString(sum[:])
+	key := chunkKey(c.algo, hash)
+
+	exists, err := c.store.Exists(c.ctx, key)
+	if err != nil {
+		return fmt.Errorf("can't check for existing chunk %s: %w", hash, err)
+	}
+
+	if !exists {
+		var compressed bytes.Buffer
+		cw, contentEncoding, _, err := compression.NewWriter(&compressed, c.algo, c.level)
+		if err != nil {
+			return fmt.Errorf("can't compress chunk %s: %w", hash, err)
+		}
+		if _, err := cw.Write(c.buf); err != nil {
+			return fmt.Errorf("can't compress chunk %s: %w", hash, err)
+		}
+		if err := cw.Close(); err != nil {
+			return fmt.Errorf("can't compress chunk %s: %w", hash, err)
+		}
+
+		_, err = c.store.Put(c.ctx, key, &compressed, int64(compressed.Len()), backend.PutOptions{
+			ContentType:     "application/octet-stream",
+			ContentEncoding: contentEncoding,
+		})
+		if err != nil {
+			return fmt.Errorf("can't upload chunk %s: %w", hash, err)
+		}
+	}
+
+	c.keys = append(c.keys, key)
+	c.buf = c.buf[:0]
+	return nil
+}
+
+// chunkKeys returns the object keys of the chunks written so far, in order.
+func (c *chunkUploader) chunkKeys() []string {
+	keys := make([]string, len(c.keys))
+	copy(keys, c.keys)
+	return keys
+}
+
+func chunkKey(algo, hash string) string {
+	return fmt.Sprintf("chunks/%s/%s", compression.Suffix(algo), hash)
+}
@@ -0,0 +1,144 @@
+// Package compression picks a compression codec for the dump stream at
+// runtime, so operators can trade upload time/size against CPU without a
+// recompile.
+package compression
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// NewWriter wraps w with the named compression algorithm ("gzip", "zstd",
+// or "xz"; "" defaults to "gzip") at the given level, and returns the
+// encoding and content-type to advertise alongside the upload. The caller
+// must Close the returned writer to flush trailing data.
+func NewWriter(w io.Writer, algo string, level int) (wc io.WriteCloser, contentEncoding string, contentType string, err error) {
+	switch algo {
+	case "", "gzip":
+		if level == 0 {
+			level = gzip.BestSpeed
+		}
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("can't create gzip writer: %w", err)
+		}
+		return gw, "gzip", "application/x-tar", nil
+
+	case "zstd":
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel(level)))
+		if err != nil {
+			return nil, "", "", fmt.Errorf("can't create zstd writer: %w", err)
+		}
+		return zw, "zstd", "application/x-tar", nil
+
+	case "xz":
+		xw, err := (xz.WriterConfig{DictCap: xzDictCap(level)}).NewWriter(w)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("can't create xz writer: %w", err)
+		}
+		return xw, "xz", "application/x-tar", nil
+
+	default:
+		return nil, "", "", fmt.Errorf("unknown compression algorithm %q", algo)
+	}
+}
+
+// NewReader wraps r with a decompressor for the named algorithm ("gzip",
+// "zstd", or "xz"; "" defaults to "gzip"). The caller must Close it.
+func NewReader(r io.Reader, algo string) (io.ReadCloser, error) {
+	switch algo {
+	case "", "gzip":
+		return gzip.NewReader(r)
+
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("can't create zstd reader: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+
+	case "xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("can't create xz reader: %w", err)
+		}
+		return io.NopCloser(xr), nil
+
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q", algo)
+	}
+}
+
+// AlgoForKey infers the compression algorithm from a dump object key's
+// extension, e.g. "go/.../dump.tar.zst" -> "zstd".
+func AlgoForKey(key string) string {
+	switch {
+	case strings.HasSuffix(key, ".zst"):
+		return "zstd"
+	case strings.HasSuffix(key, ".xz"):
+		return "xz"
+	default:
+		return "gzip"
+	}
+}
+
+// IsDumpKey reports whether key names a dump object, e.g.
+// "go/.../dump.tar.gz" or "go/.../dump.tar.zst", regardless of which
+// compression algorithm produced it.
+func IsDumpKey(key string) bool {
+	return strings.Contains(key, "/dump.tar.")
+}
+
+// Suffix returns the file extension dumps compressed with algo should use,
+// e.g. "zst" for "zstd" (as in "dump.tar.zst").
+func Suffix(algo string) string {
+	switch algo {
+	case "zstd":
+		return "zst"
+	case "xz":
+		return "xz"
+	default:
+		return "gz"
+	}
+}
+
+// xzDictCap maps the 1-22 zstd-style level scale callers pass via
+// COMPRESSION_LEVEL onto xz's dictionary size, the closest thing xz has to
+// a speed/ratio knob: a bigger dictionary finds more redundancy at the
+// cost of more memory and CPU.
+func xzDictCap(level int) int {
+	switch {
+	case level <= 0:
+		return 0 // let WriterConfig apply its own default
+	case level <= 3:
+		return 1 << 20
+	case level <= 9:
+		return 8 << 20
+	case level <= 15:
+		return 32 << 20
+	default:
+		return 64 << 20
+	}
+}
+
+// zstdLevel maps the 1-22 zstd-style level scale callers pass via
+// COMPRESSION_LEVEL onto zstd's coarser encoder levels.
+func zstdLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level <= 0:
+		return zstd.SpeedDefault
+	case level <= 3:
+		return zstd.SpeedFastest
+	case level <= 9:
+		return zstd.SpeedDefault
+	case level <= 15:
+		return zstd.SpeedBetterCompression
+	default:
+		return zstd.SpeedBestCompression
+	}
+}
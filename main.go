@@ -2,7 +2,6 @@ package main
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
@@ -10,17 +9,18 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/yandex-cloud/go-genproto/yandex/cloud/lockbox/v1"
 	ycsdk "github.com/yandex-cloud/go-sdk"
-	"golang.org/x/sync/errgroup"
+
+	"github.com/Nyoroon/ycf-dump/backend"
+	"github.com/Nyoroon/ycf-dump/compression"
 )
 
 func DumpDir(w io.Writer, dumpPath string) error {
@@ -72,6 +72,12 @@ func DumpDir(w io.Writer, dumpPath string) error {
 		fileReadable := (fileInfo.Mode()&fs.ModePerm)&0004 != 0
 		if !fileReadable {
 			hdr.Size = 0
+			if fileInfo.Mode().IsRegular() {
+				// Mark the entry so RestoreHandler can tell a placeholder
+				// for unreadable content apart from a genuinely empty file
+				// and leave whatever's already at the restore target alone.
+				hdr.PAXRecords = map[string]string{unreadablePAXRecord: "true"}
+			}
 		}
 
 		if err := archW.WriteHeader(hdr); err != nil {
@@ -124,84 +130,63 @@ func getSecret(ctx context.Context, sdk *ycsdk.SDK) (map[string]string, error) {
 	return secrets, nil
 }
 
-func NewS3Client(id, secret string) (*minio.Client, error) {
-	const s3Endpoint = "storage.yandexcloud.net"
-
-	opts := &minio.Options{
-		Creds:  credentials.NewStaticV4(id, secret, ""),
-		Secure: true,
-		Region: os.Getenv("REGION"),
-	}
-	return minio.New(s3Endpoint, opts)
-}
-
 var _ http.HandlerFunc = Handler
 
-func Handler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
+// lifecycleOnce ensures the bucket lifecycle rule is reconciled at most once
+// per cold start, rather than on every invocation.
+var lifecycleOnce sync.Once
 
+// newBackend builds the storage backend for the current request: it builds
+// a Yandex Cloud SDK client, pulls credentials out of Lockbox, and hands
+// both to backend.NewFromEnv.
+func newBackend(ctx context.Context) (backend.Backend, error) {
 	sdk, err := ycsdk.Build(
-		r.Context(),
+		ctx,
 		ycsdk.Config{
 			Credentials: ycsdk.InstanceServiceAccount(),
 		},
 	)
 	if err != nil {
-		log.Println(err.Error())
-		http.Error(w, "can't initalize sdk", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("can't initalize sdk: %w", err)
 	}
 
-	secrets, err := getSecret(r.Context(), sdk)
+	secrets, err := getSecret(ctx, sdk)
 	if err != nil {
-		log.Println(err.Error())
-		http.Error(w, "can't get secrets", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("can't get secrets: %w", err)
 	}
 
-	s3client, err := NewS3Client(secrets["AWS_ACCESS_KEY"], secrets["AWS_SECRET_KEY"])
+	return backend.NewFromEnv(ctx, secrets)
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	store, err := newBackend(r.Context())
 	if err != nil {
 		log.Println(err.Error())
-		http.Error(w, "s3 unavailable", http.StatusInternalServerError)
+		http.Error(w, "storage backend unavailable", http.StatusInternalServerError)
 		return
 	}
 
-	errg, ctx := errgroup.WithContext(r.Context())
-	pipeR, pipeW := io.Pipe()
-
-	var putInfo minio.UploadInfo
-	errg.Go(func() error {
-		var err error
-		putInfo, err = s3client.PutObject(
-			ctx,
-			os.Getenv("BUCKET"),
-			fmt.Sprintf("go/%s/dump.tar.gz", time.Now().Format(time.RFC3339)),
-			pipeR,
-			-1,
-			minio.PutObjectOptions{
-				ContentType:     "application/x-tar",
-				ContentEncoding: "gzip",
-				PartSize:        5 * 1024 * 1024,
-			},
-		)
-
-		return err
-	})
+	if lm, ok := store.(backend.LifecycleManager); ok {
+		lifecycleOnce.Do(func() {
+			if err := lm.EnsureLifecycle(r.Context()); err != nil {
+				log.Printf("can't ensure bucket lifecycle: %s", err.Error())
+			}
+		})
+	}
 
-	errg.Go(func() error {
-		gzipW, _ := gzip.NewWriterLevel(pipeW, gzip.BestSpeed)
+	ctx := r.Context()
+	run := time.Now().Format(time.RFC3339)
 
-		err := DumpDir(gzipW, "/")
-		gzipW.Close()
-		if err != nil {
-			_ = pipeW.CloseWithError(err)
-		} else {
-			pipeW.Close()
-		}
-		return err
-	})
+	level, _ := strconv.Atoi(os.Getenv("COMPRESSION_LEVEL"))
+	algo := os.Getenv("COMPRESSION")
+	uploader := newChunkUploader(ctx, store, algo, level)
 
-	err = errg.Wait()
+	err = DumpDir(uploader, "/")
+	if closeErr := uploader.Close(); err == nil {
+		err = closeErr
+	}
 
 	w.Header().Set("Server-Timing", fmt.Sprintf("total;dur=%.3f", time.Since(start).Seconds()))
 
@@ -210,17 +195,39 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	presigned, err := s3client.PresignedGetObject(
+	chunkKeys := uploader.chunkKeys()
+
+	manifestKey := fmt.Sprintf("go/%s/manifest.txt", run)
+	_, err = store.Put(
 		ctx,
-		putInfo.Bucket,
-		putInfo.Key,
-		15*time.Minute,
-		url.Values{},
+		manifestKey,
+		strings.NewReader(strings.Join(chunkKeys, "\n")),
+		-1,
+		backend.PutOptions{ContentType: "text/plain"},
 	)
 	if err != nil {
-		fmt.Fprintf(w, "error generating presigned url: %s", err.Error())
+		http.Error(w, fmt.Sprintf("can't write manifest: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
-	fmt.Fprintln(w, presigned.String())
+
+	dumpKey := fmt.Sprintf("go/%s/dump.tar.%s", run, compression.Suffix(algo))
+	if err := store.Compose(ctx, dumpKey, chunkKeys); err != nil {
+		http.Error(w, fmt.Sprintf("can't compose dump: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "dump key: %s\n", dumpKey)
+
+	presigned, note, err := store.PresignedURL(ctx, dumpKey, 15*time.Minute)
+	if err != nil {
+		fmt.Fprintf(w, "error generating presigned url for %s: %s\n", dumpKey, err.Error())
+		return
+	}
+	if presigned != "" {
+		fmt.Fprintln(w, presigned)
+	}
+	if note != "" {
+		fmt.Fprintln(w, note)
+	}
 }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Nyoroon/ycf-dump/compression"
+)
+
+// unreadablePAXRecord is the PAX header key DumpDir sets on regular files
+// it couldn't read, so RestoreHandler can tell a dump-time placeholder
+// apart from a genuinely empty file.
+const unreadablePAXRecord = "YCF.unreadable"
+
+var _ http.HandlerFunc = RestoreHandler
+
+// RestoreHandler is the inverse of Handler: it fetches a dump (identified
+// by the "key" query parameter, or the most recent one if "key" is empty or
+// "latest") and extracts it into the directory given by the "root" query
+// parameter. It refuses to write into dev/, proc/, sys/, the same prefixes
+// DumpDir skips on the way out.
+func RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	root := r.URL.Query().Get("root")
+	if root == "" {
+		http.Error(w, "root query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	store, err := newBackend(r.Context())
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "storage backend unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" || key == "latest" {
+		key, err = store.Latest(r.Context(), "go/")
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "can't find latest dump", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	rc, err := store.Get(r.Context(), key)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "can't fetch dump", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	if err := restoreDump(rc, compression.AlgoForKey(key), root); err != nil {
+		log.Println(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "restored %s to %s\n", key, root)
+}
+
+// restoreDump decompresses and untars r, writing each entry under root.
+func restoreDump(r io.Reader, algo string, root string) error {
+	decompR, err := compression.NewReader(r, algo)
+	if err != nil {
+		return fmt.Errorf("error opening compressed stream: %w", err)
+	}
+	defer decompR.Close()
+
+	tr := tar.NewReader(decompR)
+
+	var dirs []pendingDir
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar entry: %w", err)
+		}
+
+		if strings.HasPrefix(hdr.Name, "dev/") ||
+			strings.HasPrefix(hdr.Name, "proc/") ||
+			strings.HasPrefix(hdr.Name, "sys/") {
+			continue
+		}
+
+		dst, err := safeJoin(root, hdr.Name)
+		if err != nil {
+			return fmt.Errorf("error restoring %s: %w", hdr.Name, err)
+		}
+
+		if err := restoreEntry(tr, hdr, root, dst, &dirs); err != nil {
+			return fmt.Errorf("error restoring %s: %w", hdr.Name, err)
+		}
+	}
+
+	// Directories get their mode/mtime restored last, deepest first: every
+	// file or subdirectory written inside a directory bumps that
+	// directory's mtime again, so setting it during the walk would just
+	// get clobbered by later siblings.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.dst, d.mode); err != nil {
+			return fmt.Errorf("error restoring %s: %w", d.dst, err)
+		}
+		if err := os.Chtimes(d.dst, d.modTime, d.modTime); err != nil {
+			return fmt.Errorf("error restoring %s: %w", d.dst, err)
+		}
+	}
+
+	return nil
+}
+
+// pendingDir records a directory entry's mode/mtime so restoreDump can
+// apply them in a final pass, after everything that might be written
+// inside the directory.
+type pendingDir struct {
+	dst     string
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// safeJoin joins name onto root the way restoreDump's tar entries are
+// expected to, and rejects anything that would land outside root (absolute
+// paths, "../" components).
+func safeJoin(root, name string) (string, error) {
+	if filepath.IsAbs(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("refusing to extract absolute path %q", name)
+	}
+
+	dst := filepath.Join(root, filepath.FromSlash(name))
+
+	rel, err := filepath.Rel(root, dst)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to extract %q outside of %s", name, root)
+	}
+
+	return dst, nil
+}
+
+// verifyNoSymlinkAncestors refuses to extract into dst if any directory
+// between root and dst already exists as a symlink, e.g. a prior tar entry
+// that swapped a directory in the path for a symlink escaping root.
+func verifyNoSymlinkAncestors(root, dst string) error {
+	rel, err := filepath.Rel(root, filepath.Dir(dst))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+
+	cur := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, part)
+
+		info, err := os.Lstat(cur)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract through symlink %s", cur)
+		}
+	}
+
+	return nil
+}
+
+func restoreEntry(tr *tar.Reader, hdr *tar.Header, root, dst string, dirs *[]pendingDir) error {
+	if err := verifyNoSymlinkAncestors(root, dst); err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		// Create it permissive enough to populate now; its real mode and
+		// mtime are restored in restoreDump's final pass once every
+		// entry that might land inside it has been written.
+		if err := os.MkdirAll(dst, hdr.FileInfo().Mode()|0o700); err != nil {
+			return err
+		}
+		*dirs = append(*dirs, pendingDir{dst: dst, mode: hdr.FileInfo().Mode(), modTime: hdr.ModTime})
+		return nil
+	case tar.TypeSymlink:
+		target := hdr.Linkname
+		if filepath.IsAbs(filepath.FromSlash(target)) {
+			target = filepath.FromSlash(target)
+		} else {
+			target = filepath.Join(filepath.Dir(dst), filepath.FromSlash(target))
+		}
+		rel, err := filepath.Rel(root, target)
+		if err != nil {
+			return err
+		}
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to create symlink to %q outside of %s", hdr.Linkname, root)
+		}
+
+		_ = os.Remove(dst)
+		if err := os.Symlink(hdr.Linkname, dst); err != nil {
+			return err
+		}
+		return nil // symlink permissions/mtime aren't meaningfully restorable
+	default:
+		if hdr.PAXRecords[unreadablePAXRecord] == "true" {
+			// No content was recorded for this entry because it was
+			// unreadable at dump time; restoring it would silently
+			// truncate whatever's already at dst, so leave it alone.
+			log.Printf("skipping %s: no content was dumped (unreadable at dump time)", hdr.Name)
+			return nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode())
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return fmt.Errorf("can't write file contents: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Chmod(dst, hdr.FileInfo().Mode()); err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, hdr.ModTime, hdr.ModTime)
+}